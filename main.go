@@ -9,10 +9,20 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/exp/rand"
 	distuv "gonum.org/v1/gonum/stat/distuv"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -23,6 +33,44 @@ func init() {
 	start = time.Now()
 }
 
+// noVersionLabel is the "version" label value used when a request was
+// rejected or unmatched before a version could be resolved.
+const noVersionLabel = "none"
+
+// Metrics describing the mock's own behavior, exposed on /metrics. All are
+// labeled with both the URI and the matched version index (or
+// noVersionLabel) so tests can distinguish traffic per version.
+var (
+	requestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "metrics_mock_requests_total",
+		Help: "Total number of requests handled, by URI and matched version index.",
+	}, []string{"uri", "version"})
+
+	responseLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "metrics_mock_response_latency_seconds",
+		Help: "Latency of responses served, by URI and matched version index.",
+	}, []string{"uri", "version"})
+
+	headerMismatchCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "metrics_mock_header_mismatches_total",
+		Help: "Total number of requests rejected for mismatched headers, by URI and matched version index.",
+	}, []string{"uri", "version"})
+
+	unmatchedVersionCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "metrics_mock_unmatched_version_total",
+		Help: "Total number of requests with no matching version, by URI and matched version index.",
+	}, []string{"uri", "version"})
+
+	lastValue = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metrics_mock_last_value",
+		Help: "Last synthetic value returned, by URI and matched version index.",
+	}, []string{"uri", "version"})
+)
+
+func init() {
+	prometheus.MustRegister(requestCount, responseLatency, headerMismatchCount, unmatchedVersionCount, lastValue)
+}
+
 // HandlerFunc type is the type of function used as http request handler
 type HandlerFunc func(w http.ResponseWriter, req *http.Request)
 
@@ -41,11 +89,18 @@ Example prometheus response
 }
 */
 
-// PrometheusResult is the result section of PrometheusResponseData
-type PrometheusResult []struct {
-	Value []interface{} `json:"value"`
+// PrometheusSample is one entry of a Prometheus vector result. Metric
+// carries the labels distinguishing this sample, e.g. {"le": "0.5"} for a
+// histogram bucket or {"quantile": "0.9"} for a summary quantile; it is
+// omitted for plain counter/gauge samples.
+type PrometheusSample struct {
+	Metric map[string]string `json:"metric,omitempty"`
+	Value  []interface{}     `json:"value"`
 }
 
+// PrometheusResult is the result section of PrometheusResponseData
+type PrometheusResult []PrometheusSample
+
 // PrometheusResponseData is the data section of Prometheus response
 type PrometheusResponseData struct {
 	ResultType string           `json:"resultType"`
@@ -58,30 +113,176 @@ type PrometheusResponse struct {
 	Data   PrometheusResponseData `json:"data"`
 }
 
+/*
+Example prometheus range query response
+{
+    "status": "success",
+    "data": {
+      "resultType": "matrix",
+      "result": [
+        {
+          "values": [[1556823494.744, "21.7639"], [1556823554.744, "23.1"]]
+        }
+      ]
+    }
+}
+*/
+
+// PrometheusMatrixSample is one entry of a Prometheus matrix result, see
+// PrometheusSample for the meaning of Metric.
+type PrometheusMatrixSample struct {
+	Metric map[string]string `json:"metric,omitempty"`
+	Values [][]interface{}   `json:"values"`
+}
+
+// PrometheusMatrixResult is the result section of PrometheusMatrixResponseData
+type PrometheusMatrixResult []PrometheusMatrixSample
+
+// PrometheusMatrixResponseData is the data section of a Prometheus range query response
+type PrometheusMatrixResponseData struct {
+	ResultType string                 `json:"resultType"`
+	Result     PrometheusMatrixResult `json:"result"`
+}
+
+// PrometheusMatrixResponse struct captures a range-query (matrix) response from prometheus
+type PrometheusMatrixResponse struct {
+	Status string                       `json:"status"`
+	Data   PrometheusMatrixResponseData `json:"data"`
+}
+
+/*
+Example datadog response
+{
+    "status": "ok",
+    "series": [
+      {
+        "metric": "trace.express.request.duration",
+        "scope": "service:reviews-v2",
+        "pointlist": [[1556823494744, 21.7639], [1556823554744, 23.1]]
+      }
+    ]
+}
+*/
+
+// DatadogSeries is a single series entry in a Datadog query response
+type DatadogSeries struct {
+	Metric    string      `json:"metric"`
+	Scope     string      `json:"scope"`
+	Pointlist [][]float64 `json:"pointlist"`
+}
+
+// DatadogResponse struct captures a response from Datadog's /api/v1/query
+type DatadogResponse struct {
+	Status string          `json:"status"`
+	Series []DatadogSeries `json:"series"`
+}
+
 func getHandlerFunc(conf URIConf) HandlerFunc {
 	switch conf.Provider {
+	case "Datadog":
+		var f HandlerFunc = func(w http.ResponseWriter, req *http.Request) {
+			reqStart := time.Now()
+			versionLabel := noVersionLabel
+			defer func() {
+				responseLatency.WithLabelValues(conf.URI, versionLabel).Observe(time.Since(reqStart).Seconds())
+			}()
+			if !conf.MatchHeaders(req) {
+				requestCount.WithLabelValues(conf.URI, versionLabel).Inc()
+				headerMismatchCount.WithLabelValues(conf.URI, versionLabel).Inc()
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("headers are not matching"))
+			} else {
+				if version, idx := conf.GetVersion(req); version != nil {
+					versionLabel = strconv.Itoa(idx)
+					requestCount.WithLabelValues(conf.URI, versionLabel).Inc()
+					fromTime, toTime, step := conf.datadogRangeParams(req)
+					toTime = clampRangeEnd(fromTime, toTime, step)
+					pointlist := make([][]float64, 0)
+					var last float64
+					for t := fromTime; !t.After(toTime); t = t.Add(step) {
+						// Datadog's pointlist is a single scalar series, so for
+						// histogram/summary metrics we surface only the
+						// primary (first) sample.
+						last = getValue(version, windowElapsed(t, fromTime))[0].Value
+						pointlist = append(pointlist, []float64{float64(t.UnixNano() / int64(time.Millisecond)), last})
+					}
+					lastValue.WithLabelValues(conf.URI, versionLabel).Set(last)
+					b, _ := json.Marshal(DatadogResponse{
+						Status: "ok",
+						Series: []DatadogSeries{
+							{
+								Metric:    conf.Datadog.Metric,
+								Scope:     conf.Datadog.Scope,
+								Pointlist: pointlist,
+							},
+						},
+					})
+					w.WriteHeader(http.StatusOK)
+					w.Write(b)
+					log.Info(version)
+				} else {
+					requestCount.WithLabelValues(conf.URI, versionLabel).Inc()
+					unmatchedVersionCount.WithLabelValues(conf.URI, versionLabel).Inc()
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write([]byte("500 - cannot find any matching version in request!"))
+				}
+			}
+		}
+		return f
 	case "Prometheus":
 		var f HandlerFunc = func(w http.ResponseWriter, req *http.Request) {
+			reqStart := time.Now()
+			versionLabel := noVersionLabel
+			defer func() {
+				responseLatency.WithLabelValues(conf.URI, versionLabel).Observe(time.Since(reqStart).Seconds())
+			}()
 			if !conf.MatchHeaders(req) {
+				requestCount.WithLabelValues(conf.URI, versionLabel).Inc()
+				headerMismatchCount.WithLabelValues(conf.URI, versionLabel).Inc()
 				w.WriteHeader(http.StatusUnauthorized)
 				w.Write([]byte("headers are not matching"))
 			} else {
-				if version := conf.GetVersion(req); version != nil {
+				if version, idx := conf.GetVersion(req); version != nil {
+					versionLabel = strconv.Itoa(idx)
+					requestCount.WithLabelValues(conf.URI, versionLabel).Inc()
+					if isRangeQuery(req) {
+						startTime, endTime, step := conf.rangeParams(req)
+						result, last := buildMatrixResult(version, startTime, endTime, step)
+						lastValue.WithLabelValues(conf.URI, versionLabel).Set(last)
+						b, _ := json.Marshal(PrometheusMatrixResponse{
+							Status: "success",
+							Data: PrometheusMatrixResponseData{
+								ResultType: "matrix",
+								Result:     result,
+							},
+						})
+						w.WriteHeader(http.StatusOK)
+						w.Write(b)
+						log.Info(version)
+						return
+					}
+					samples := getValue(version, elapsedSince(req))
+					result := make(PrometheusResult, 0, len(samples))
+					for _, s := range samples {
+						result = append(result, PrometheusSample{
+							Metric: s.Metric,
+							Value:  []interface{}{1556823494.744, fmt.Sprint(s.Value)},
+						})
+					}
+					lastValue.WithLabelValues(conf.URI, versionLabel).Set(samples[0].Value)
 					b, _ := json.Marshal(PrometheusResponse{
 						Status: "success",
 						Data: PrometheusResponseData{
 							ResultType: "vector",
-							Result: PrometheusResult{
-								{
-									Value: []interface{}{1556823494.744, fmt.Sprint(getValue(version))},
-								},
-							},
+							Result:     result,
 						},
 					})
 					w.WriteHeader(http.StatusOK)
 					w.Write(b)
 					log.Info(version)
 				} else {
+					requestCount.WithLabelValues(conf.URI, versionLabel).Inc()
+					unmatchedVersionCount.WithLabelValues(conf.URI, versionLabel).Inc()
 					w.WriteHeader(http.StatusInternalServerError)
 					w.Write([]byte("500 - cannot find any matching version in request!"))
 				}
@@ -93,20 +294,325 @@ func getHandlerFunc(conf URIConf) HandlerFunc {
 	}
 }
 
-func getValue(version *VersionInfo) float64 {
-	elapsed := time.Now().Sub(start)
-	if version.Metric.Type == "counter" {
-		return elapsed.Seconds() * version.Metric.Rate
+// isRangeQuery reports whether the request carries the start/end/step params
+// that identify a Prometheus /api/v1/query_range call.
+func isRangeQuery(req *http.Request) bool {
+	q := req.URL.Query()
+	return len(q.Get("start")) > 0 || len(q.Get("end")) > 0 || len(q.Get("step")) > 0
+}
+
+// rangeParams resolves the start, end and step of a range query, falling back
+// to the URIConf's configured defaults when the request omits them.
+func (u *URIConf) rangeParams(req *http.Request) (time.Time, time.Time, time.Duration) {
+	q := req.URL.Query()
+	now := referenceNow(req)
+
+	startTime := now.Add(-u.Range.Duration())
+	if v, err := strconv.ParseFloat(q.Get("start"), 64); err == nil {
+		startTime = epochToTime(v)
+	}
+
+	endTime := now
+	if v, err := strconv.ParseFloat(q.Get("end"), 64); err == nil {
+		endTime = epochToTime(v)
+	}
+
+	step := u.Range.StepDuration()
+	if v, err := strconv.ParseFloat(q.Get("step"), 64); err == nil && v > 0 {
+		step = time.Duration(v * float64(time.Second))
+	}
+
+	return startTime, endTime, step
+}
+
+// maxRangePoints bounds how many points a single query_range/Datadog range
+// query can generate. start/end/step are entirely caller-controlled, so a
+// wide window paired with a tiny step would otherwise iterate effectively
+// unbounded and hang or exhaust memory.
+const maxRangePoints = 11000
+
+// clampRangeEnd truncates endTime so that iterating startTime..endTime in
+// steps of step emits at most maxRangePoints points, logging when it does.
+func clampRangeEnd(startTime, endTime time.Time, step time.Duration) time.Time {
+	if step <= 0 || endTime.Before(startTime) {
+		return endTime
+	}
+	points := int64(endTime.Sub(startTime)/step) + 1
+	if points <= maxRangePoints {
+		return endTime
+	}
+	truncated := startTime.Add(step * (maxRangePoints - 1))
+	log.Warnf("range query would emit %d points (start=%s end=%s step=%s), truncating to %d", points, startTime, endTime, step, maxRangePoints)
+	return truncated
+}
+
+func epochToTime(epochSeconds float64) time.Time {
+	return time.Unix(0, int64(epochSeconds*float64(time.Second)))
+}
+
+// windowElapsed computes the elapsed-time input to getValue for a step at
+// absolute time t within a query window anchored at windowStart. A
+// query_range/Datadog window is almost always in the past relative to when
+// the mock process booted, so anchoring to the process's start time (as
+// opposed to the window's own start) produced a negative elapsed: negative,
+// non-monotonic counter values, and a panic for gauge/histogram/summary
+// sampling since a Beta distribution requires Alpha, Beta > 0.
+func windowElapsed(t, windowStart time.Time) time.Duration {
+	elapsed := t.Sub(windowStart)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return elapsed
+}
+
+// referenceNow returns the request's "?t=<unix_seconds>" override, if
+// present, as the time to treat as "now" — otherwise the actual current
+// time. rangeParams and datadogRangeParams thread every would-be time.Now()
+// call for resolving a window's start/end through this, so pinning ?t makes
+// the range and Datadog paths deterministic for golden-file assertions. The
+// instant-vector path needs an elapsed duration rather than a point in time,
+// so it has its own override handling in elapsedSince.
+func referenceNow(req *http.Request) time.Time {
+	if v, err := strconv.ParseFloat(req.URL.Query().Get("t"), 64); err == nil {
+		return epochToTime(v)
+	}
+	return time.Now()
+}
+
+// elapsedSince returns the elapsed time to feed into getValue for the
+// instant-vector path. Unlike referenceNow (a point in wall-clock time),
+// callers need an elapsed *duration* here, so a pinned ?t is taken as that
+// duration directly rather than a time to diff against the process's start:
+// diffing against start would still make the result depend on process boot
+// time and host, and a realistic small ?t would go deeply negative and panic
+// gauge/histogram/summary sampling. With no ?t override this is simply the
+// time since the mock started.
+func elapsedSince(req *http.Request) time.Duration {
+	if v, err := strconv.ParseFloat(req.URL.Query().Get("t"), 64); err == nil {
+		return time.Duration(v * float64(time.Second))
+	}
+	return time.Now().Sub(start)
+}
+
+// buildMatrixResult evaluates getValue at each step across the range and
+// groups the resulting samples into one matrix series per distinct set of
+// metric labels (so a histogram's buckets each become their own series). It
+// also returns the primary (first) sample's final value for the gauge
+// exposed on /metrics.
+func buildMatrixResult(version *VersionInfo, startTime, endTime time.Time, step time.Duration) (PrometheusMatrixResult, float64) {
+	endTime = clampRangeEnd(startTime, endTime, step)
+	series := make(map[string]*PrometheusMatrixSample)
+	var order []string
+	var last float64
+
+	for t := startTime; !t.After(endTime); t = t.Add(step) {
+		samples := getValue(version, windowElapsed(t, startTime))
+		last = samples[0].Value
+		for _, s := range samples {
+			key := metricKey(s.Metric)
+			entry, ok := series[key]
+			if !ok {
+				entry = &PrometheusMatrixSample{Metric: s.Metric}
+				series[key] = entry
+				order = append(order, key)
+			}
+			entry.Values = append(entry.Values, []interface{}{float64(t.Unix()), fmt.Sprint(s.Value)})
+		}
+	}
+
+	result := make(PrometheusMatrixResult, 0, len(order))
+	for _, key := range order {
+		result = append(result, *series[key])
+	}
+	return result, last
+}
+
+// metricKey returns a stable string key for grouping matrix samples that
+// share the same metric labels into one series.
+func metricKey(metric map[string]string) string {
+	return fmt.Sprintf("%v", metric)
+}
+
+// datadogRangeParams resolves the from, to and step of a Datadog query,
+// falling back to the URIConf's configured defaults when the request
+// omits the epoch-second "from"/"to" params.
+func (u *URIConf) datadogRangeParams(req *http.Request) (time.Time, time.Time, time.Duration) {
+	q := req.URL.Query()
+	now := referenceNow(req)
+
+	fromTime := now.Add(-u.Range.Duration())
+	if v, err := strconv.ParseFloat(q.Get("from"), 64); err == nil {
+		fromTime = epochToTime(v)
+	}
+
+	toTime := now
+	if v, err := strconv.ParseFloat(q.Get("to"), 64); err == nil {
+		toTime = epochToTime(v)
+	}
+
+	return fromTime, toTime, u.Datadog.StepDuration()
+}
+
+// RangeConf carries the default range/step used to answer a query_range
+// request when the caller omits start, end or step. Start and End are used
+// only for their difference: an omitted start defaults to that span before
+// "now" and an omitted end to "now" itself.
+type RangeConf struct {
+	Start float64 `yaml:"start"`
+	End   float64 `yaml:"end"`
+	Step  float64 `yaml:"step"`
+}
+
+// Duration returns the configured start-to-end span as a time.Duration.
+func (r RangeConf) Duration() time.Duration {
+	return time.Duration((r.End - r.Start) * float64(time.Second))
+}
+
+// StepDuration returns the configured step as a time.Duration.
+func (r RangeConf) StepDuration() time.Duration {
+	if r.Step <= 0 {
+		return time.Minute
 	}
-	if version.Metric.Type == "gauge" {
-		log.Info("metricinfo...", version.Metric)
-		beta := distuv.Beta{
-			Alpha: (elapsed.Seconds() + 1.0) * version.Metric.Alpha,
-			Beta:  (elapsed.Seconds() + 1.0) * version.Metric.Beta,
-		}.Rand()
-		return version.Metric.Shift + beta*version.Metric.Multiplier
+	return time.Duration(r.Step * float64(time.Second))
+}
+
+// DatadogConf carries the Datadog-specific fields surfaced in a
+// /api/v1/query response: the scope tags and metric name to report, and
+// the step used to space out the pointlist.
+type DatadogConf struct {
+	Scope  string  `yaml:"scope"`
+	Metric string  `yaml:"metric"`
+	Step   float64 `yaml:"step"`
+}
+
+// StepDuration returns the configured step as a time.Duration.
+func (d DatadogConf) StepDuration() time.Duration {
+	if d.Step <= 0 {
+		return time.Minute
+	}
+	return time.Duration(d.Step * float64(time.Second))
+}
+
+// PromSample is a single labeled sample produced by getValue: a bare value
+// for counter/gauge metrics, or one of several label-distinguished points,
+// e.g. {"le": "0.5"} for a histogram bucket or {"quantile": "0.9"} for a
+// summary quantile.
+type PromSample struct {
+	Metric map[string]string
+	Value  float64
+}
+
+// histogramObservationCount is the nominal number of observations the mock
+// pretends to have made when deriving cumulative histogram bucket counts
+// and the summary/histogram count and sum.
+const histogramObservationCount = 1000.0
+
+// metricGenerators dispatches a MetricInfo's Type to the function that
+// produces its samples.
+var metricGenerators = map[string]func(*VersionInfo, time.Duration) []PromSample{
+	"counter":   counterSamples,
+	"gauge":     gaugeSamples,
+	"histogram": histogramSamples,
+	"summary":   summarySamples,
+}
+
+// getValue computes the sample(s) for a version's metric at the given
+// elapsed time. Counter and gauge metrics always produce exactly one
+// unlabeled sample; histogram and summary metrics produce one sample per
+// bucket/quantile plus a _sum and _count sample.
+func getValue(version *VersionInfo, elapsed time.Duration) []PromSample {
+	if gen, ok := metricGenerators[version.Metric.Type]; ok {
+		return gen(version, elapsed)
 	}
-	return 21.7639
+	return []PromSample{{Value: 21.7639}}
+}
+
+// betaSampler builds the Beta distribution a version's gauge, histogram and
+// summary samples are all drawn from, shifted/scaled by Shift and Multiplier.
+// If the version carries a non-zero Seed, the distribution draws from a
+// dedicated, deterministically-seeded source instead of the package-global
+// RNG, so repeated runs produce identical samples.
+func betaSampler(version *VersionInfo, elapsed time.Duration) distuv.Beta {
+	beta := distuv.Beta{
+		Alpha: (elapsed.Seconds() + 1.0) * version.Metric.Alpha,
+		Beta:  (elapsed.Seconds() + 1.0) * version.Metric.Beta,
+	}
+	if version.Seed != 0 {
+		beta.Src = rand.New(rand.NewSource(uint64(version.Seed)))
+	}
+	return beta
+}
+
+func counterSamples(version *VersionInfo, elapsed time.Duration) []PromSample {
+	return []PromSample{{Value: elapsed.Seconds() * version.Metric.Rate}}
+}
+
+func gaugeSamples(version *VersionInfo, elapsed time.Duration) []PromSample {
+	log.Info("metricinfo...", version.Metric)
+	beta := betaSampler(version, elapsed).Rand()
+	return []PromSample{{Value: version.Metric.Shift + beta*version.Metric.Multiplier}}
+}
+
+// bucketCDF returns the Beta distribution's cumulative probability at a
+// histogram bucket's upper bound, scaled by shift/multiplier. Multiplier ==
+// 0 would otherwise divide by zero; it degenerates the distribution to the
+// single point shift, so every bucket at or above shift is fully cumulative
+// and none below it are.
+func bucketCDF(beta distuv.Beta, bucket, shift, multiplier float64) float64 {
+	if multiplier == 0 {
+		if bucket >= shift {
+			return 1
+		}
+		return 0
+	}
+	return beta.CDF((bucket - shift) / multiplier)
+}
+
+// histogramSamples draws from the version's Beta distribution to derive
+// cumulative per-bucket counts (via the distribution's CDF) plus a _sum and
+// _count sample, mirroring a Prometheus histogram's _bucket/_sum/_count
+// series.
+func histogramSamples(version *VersionInfo, elapsed time.Duration) []PromSample {
+	m := version.Metric
+	beta := betaSampler(version, elapsed)
+	samples := make([]PromSample, 0, len(m.Buckets)+2)
+
+	for _, bucket := range m.Buckets {
+		samples = append(samples, PromSample{
+			Metric: map[string]string{"__name__": "_bucket", "le": fmt.Sprint(bucket)},
+			Value:  bucketCDF(beta, bucket, m.Shift, m.Multiplier) * histogramObservationCount,
+		})
+	}
+	samples = append(samples, PromSample{
+		Metric: map[string]string{"__name__": "_bucket", "le": "+Inf"},
+		Value:  histogramObservationCount,
+	})
+
+	mean := m.Shift + beta.Mean()*m.Multiplier
+	samples = append(samples, PromSample{Metric: map[string]string{"__name__": "_sum"}, Value: mean * histogramObservationCount})
+	samples = append(samples, PromSample{Metric: map[string]string{"__name__": "_count"}, Value: histogramObservationCount})
+	return samples
+}
+
+// summarySamples draws from the version's Beta distribution to derive a
+// value per configured quantile (via the distribution's inverse CDF) plus a
+// _sum and _count sample, mirroring a Prometheus summary's series.
+func summarySamples(version *VersionInfo, elapsed time.Duration) []PromSample {
+	m := version.Metric
+	beta := betaSampler(version, elapsed)
+	samples := make([]PromSample, 0, len(m.Quantiles)+2)
+
+	for _, q := range m.Quantiles {
+		samples = append(samples, PromSample{
+			Metric: map[string]string{"quantile": fmt.Sprint(q)},
+			Value:  m.Shift + beta.Quantile(q)*m.Multiplier,
+		})
+	}
+
+	mean := m.Shift + beta.Mean()*m.Multiplier
+	samples = append(samples, PromSample{Metric: map[string]string{"__name__": "_sum"}, Value: mean * histogramObservationCount})
+	samples = append(samples, PromSample{Metric: map[string]string{"__name__": "_count"}, Value: histogramObservationCount})
+	return samples
 }
 
 // Param is simply a name-value pair representing name and value of HTTP query param
@@ -117,18 +623,26 @@ type Param struct {
 
 // MetricInfo provides information about the metric to be generated
 type MetricInfo struct {
-	Type       string  `yaml:"type"`
-	Rate       float64 `yaml:"rate"`
-	Shift      float64 `yaml:"shift"`
-	Multiplier float64 `yaml:"multiplier"`
-	Alpha      float64 `yaml:"alpha"`
-	Beta       float64 `yaml:"beta"`
+	Type       string    `yaml:"type"`
+	Rate       float64   `yaml:"rate"`
+	Shift      float64   `yaml:"shift"`
+	Multiplier float64   `yaml:"multiplier"`
+	Alpha      float64   `yaml:"alpha"`
+	Beta       float64   `yaml:"beta"`
+	// Buckets is the set of upper bucket bounds for a "histogram" metric.
+	Buckets []float64 `yaml:"buckets"`
+	// Quantiles is the set of quantiles (e.g. 0.5, 0.9, 0.99) for a
+	// "summary" metric.
+	Quantiles []float64 `yaml:"quantiles"`
 }
 
 // VersionInfo struct provides the param and metric information for a version
 type VersionInfo struct {
 	Params []Param    `yaml:"params"`
 	Metric MetricInfo `yaml:"metric"`
+	// Seed, if non-zero, seeds this version's Beta sampler so that
+	// gauge/histogram/summary values are reproducible across runs.
+	Seed int64 `yaml:"seed"`
 }
 
 // URIConf is the metrics gen configuration for a URI
@@ -137,6 +651,12 @@ type URIConf struct {
 	Headers  map[string]string `yaml:"headers"`
 	URI      string            `yaml:"uri"`
 	Provider string            `yaml:"provider"`
+	// Range supplies the default window span and step used to answer a
+	// query_range request when the caller omits those params.
+	Range RangeConf `yaml:"range"`
+	// Datadog supplies the scope, metric name and step surfaced in a
+	// Datadog /api/v1/query response.
+	Datadog DatadogConf `yaml:"datadog"`
 }
 
 // MatchHeaders ensures that the headers in URIConf match the headers in the request
@@ -149,9 +669,11 @@ func (u *URIConf) MatchHeaders(req *http.Request) bool {
 	return true
 }
 
-// GetVersion finds the correct version in URIConf based on params in the request or returns nil if no matching version is found
-func (u *URIConf) GetVersion(req *http.Request) *VersionInfo {
-	for _, version := range u.Versions {
+// GetVersion finds the correct version in URIConf based on params in the
+// request, along with its index within Versions, or returns (nil, -1) if no
+// matching version is found.
+func (u *URIConf) GetVersion(req *http.Request) (*VersionInfo, int) {
+	for i, version := range u.Versions {
 		found := true
 		for _, param := range version.Params {
 			val, ok := req.URL.Query()[param.Name]
@@ -174,51 +696,165 @@ func (u *URIConf) GetVersion(req *http.Request) *VersionInfo {
 			}
 		}
 		if found { // return the first version found
-			return &version
+			return &version, i
 		}
 	}
-	return nil
+	return nil, -1
 }
 
-func main() {
-	// find config url from env
-	configURL := os.Getenv("CONFIG_URL")
-	if len(configURL) == 0 {
-		panic("No config URL supplied")
-	}
+// reloadableHandler dispatches requests to the currently active table of
+// per-URI handlers. It is mounted at "/" on http.DefaultServeMux in place of
+// registering each URI there: DefaultServeMux has no way to deregister a
+// pattern, so a config reload couldn't otherwise drop a URI that
+// disappeared from the new config.
+type reloadableHandler struct {
+	handlers atomic.Value // map[string]HandlerFunc
+}
 
-	// read in config from url into config struct
-	resp, err := http.Get(configURL)
-	if err != nil {
-		panic("HTTP GET with configured url did not succeed: " + configURL)
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	handlers, _ := h.handlers.Load().(map[string]HandlerFunc)
+	if f, ok := handlers[req.URL.Path]; ok {
+		f(w, req)
+		return
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	http.NotFound(w, req)
+}
 
-	if err != nil {
-		panic(err)
+// set atomically swaps in a new handler table.
+func (h *reloadableHandler) set(handlers map[string]HandlerFunc) {
+	h.handlers.Store(handlers)
+}
+
+// fetchURIConfs retrieves and parses the YAML config at configURL, which is
+// either an http(s):// URL or a file:// path, and validates that its URIs
+// are unique.
+func fetchURIConfs(configURL string) ([]URIConf, error) {
+	var body []byte
+	if path := strings.TrimPrefix(configURL, "file://"); path != configURL {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	} else {
+		resp, err := http.Get(configURL)
+		if err != nil {
+			return nil, fmt.Errorf("HTTP GET with configured url did not succeed: %s: %v", configURL, err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = b
 	}
 
 	var uriConfs []URIConf
-	err = yaml.Unmarshal(body, &uriConfs)
-	if err != nil {
-		panic(err)
+	if err := yaml.Unmarshal(body, &uriConfs); err != nil {
+		return nil, err
 	}
 
 	// check if URIs are unique
 	uriset := make(map[string]struct{})
 	for _, conf := range uriConfs {
 		if _, ok := uriset[conf.URI]; ok {
-			log.Error(uriset)
-			log.Error(conf.URI)
-			panic("URIs are not unique")
+			return nil, fmt.Errorf("URIs are not unique: %s", conf.URI)
 		}
 		uriset[conf.URI] = struct{}{}
 	}
 
+	return uriConfs, nil
+}
+
+// buildHandlerTable builds the URI -> HandlerFunc table a reloadableHandler
+// dispatches against.
+func buildHandlerTable(uriConfs []URIConf) map[string]HandlerFunc {
+	handlers := make(map[string]HandlerFunc, len(uriConfs))
 	for _, conf := range uriConfs {
-		http.HandleFunc(conf.URI, getHandlerFunc(conf))
+		handlers[conf.URI] = getHandlerFunc(conf)
+	}
+	return handlers
+}
+
+// reloadConfig re-fetches configURL and atomically swaps mux's handler
+// table. On parse failure it logs the error and leaves the existing config
+// in place.
+func reloadConfig(configURL string, mux *reloadableHandler) {
+	uriConfs, err := fetchURIConfs(configURL)
+	if err != nil {
+		log.Error("failed to reload config, keeping previous config: ", err)
+		return
+	}
+	mux.set(buildHandlerTable(uriConfs))
+	log.Info("reloaded config from ", configURL)
+}
+
+// watchForReload triggers a reloadConfig on SIGHUP, and additionally on
+// file-change events when configURL points at a local file, so iterating on
+// the YAML doesn't require restarting the mock.
+func watchForReload(configURL string, mux *reloadableHandler) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			log.Info("received SIGHUP, reloading config")
+			reloadConfig(configURL, mux)
+		}
+	}()
+
+	path := strings.TrimPrefix(configURL, "file://")
+	if path == configURL {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error("failed to start config file watcher: ", err)
+		return
+	}
+	// Watch the parent directory rather than the file itself: an atomic
+	// save-via-rename (as most editors do) replaces the watched file's inode,
+	// which would silently drop a file-level watch after the first change.
+	// Only events naming the config file itself trigger a reload, so a
+	// symlink swap elsewhere in the directory (such as the "..data" link of a
+	// k8s ConfigMap mount) does not.
+	dir := filepath.Dir(path)
+	if err := watcher.Add(dir); err != nil {
+		log.Error("failed to watch config directory: ", err)
+		return
+	}
+	go func() {
+		for event := range watcher.Events {
+			if filepath.Clean(event.Name) != filepath.Clean(path) {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				log.Info("config file changed, reloading: ", event)
+				reloadConfig(configURL, mux)
+			}
+		}
+	}()
+}
+
+func main() {
+	// find config url from env
+	configURL := os.Getenv("CONFIG_URL")
+	if len(configURL) == 0 {
+		panic("No config URL supplied")
+	}
+
+	uriConfs, err := fetchURIConfs(configURL)
+	if err != nil {
+		panic(err)
 	}
 
+	mux := &reloadableHandler{}
+	mux.set(buildHandlerTable(uriConfs))
+
+	watchForReload(configURL, mux)
+
+	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/", mux)
+
 	http.ListenAndServe(":8080", nil)
 }